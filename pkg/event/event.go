@@ -0,0 +1,127 @@
+// Package event defines the event types shared between mcpspy's eBPF data
+// path and its user-space consumers.
+package event
+
+// EventType identifies the kind of event carried on an event channel.
+type EventType uint8
+
+const (
+	EventTypeFSRead EventType = iota
+	EventTypeFSWrite
+	EventTypeFSJsonRead
+	EventTypeFSJsonWrite
+	EventTypeFSSessionDropped
+	EventTypeFSJsonGap
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventTypeFSRead:
+		return "fs_read"
+	case EventTypeFSWrite:
+		return "fs_write"
+	case EventTypeFSJsonRead:
+		return "fs_json_read"
+	case EventTypeFSJsonWrite:
+		return "fs_json_write"
+	case EventTypeFSSessionDropped:
+		return "fs_session_dropped"
+	case EventTypeFSJsonGap:
+		return "fs_json_gap"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is implemented by all events delivered on SessionManager's channels.
+type Event interface {
+	Type() EventType
+}
+
+// EventHeader carries the fields common to every event.
+type EventHeader struct {
+	EventType EventType
+	PID       uint32
+	CommBytes [16]uint8
+}
+
+// Type implements Event.
+func (h EventHeader) Type() EventType {
+	return h.EventType
+}
+
+// MaxDataSize is the maximum number of bytes captured per FSDataEvent,
+// matching the eBPF read/write probe's buffer size.
+const MaxDataSize = 4096
+
+// FSDataEvent is a raw filesystem read/write event captured by the eBPF
+// probes, before JSON reassembly.
+type FSDataEvent struct {
+	EventHeader
+	FilePtr uint64
+	// Offset is the kernel `pos` argument of the read/write syscall that
+	// produced this chunk, used to reorder fragments that arrive out of
+	// order (e.g. concurrent readers, split probes, NFS/overlayfs reads).
+	Offset  uint64
+	Size    uint32
+	BufSize uint32
+	Buf     [MaxDataSize]byte
+}
+
+// Buffer returns the captured bytes for this event.
+func (e *FSDataEvent) Buffer() []byte {
+	return e.Buf[:e.BufSize]
+}
+
+// FSSessionDroppedEvent reports that a SessionManager discarded a session
+// before it could produce a complete frame, e.g. due to an idle timeout or
+// a buffer size limit. Reason is a short machine-readable code such as
+// "buffer_limit_exceeded", "idle_ttl_expired", or "max_sessions_exceeded".
+type FSSessionDroppedEvent struct {
+	EventHeader
+	FilePtr uint64
+	Reason  string
+}
+
+// FSJsonGapEvent is a diagnostic reporting that a session's per-file offset
+// stream had a gap that went unresolved for longer than its gap timeout.
+// Offset is where the missing range began; bytes before it had already been
+// flushed. The session resyncs by scanning forward to the next '{' or '['
+// once this fires, discarding the pending bytes in front of it.
+type FSJsonGapEvent struct {
+	EventHeader
+	FilePtr uint64
+	Offset  uint64
+}
+
+// FramerKind identifies which Framer produced an FSJsonEvent's payload.
+type FramerKind uint8
+
+const (
+	FramerKindUnknown FramerKind = iota
+	FramerKindJSONStream
+	FramerKindNDJSON
+	FramerKindLSP
+)
+
+func (k FramerKind) String() string {
+	switch k {
+	case FramerKindJSONStream:
+		return "json_stream"
+	case FramerKindNDJSON:
+		return "ndjson"
+	case FramerKindLSP:
+		return "lsp"
+	default:
+		return "unknown"
+	}
+}
+
+// FSJsonEvent is a complete JSON payload reassembled from one or more
+// FSDataEvents on the same session.
+type FSJsonEvent struct {
+	EventHeader
+	FilePtr uint64
+	Payload []byte
+	Framer  FramerKind
+}
@@ -0,0 +1,175 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/alex-ilgayev/mcpspy/pkg/event"
+)
+
+// lspContentLengthHeader is the header MCP servers emit when using the
+// LSP base protocol (Content-Length: N\r\n\r\n<payload>) over stdio.
+const lspContentLengthHeader = "Content-Length:"
+
+// Framer incrementally parses complete frames out of an accumulating byte
+// stream. Feed is given the full data accumulated for a session (the
+// previous remaining bytes plus any newly arrived data) and returns the
+// complete frames found, in order, plus the bytes to retain for the next
+// call.
+type Framer interface {
+	Feed(data []byte) (frames [][]byte, remaining []byte, err error)
+
+	// Kind identifies which framing this Framer implements, recorded on
+	// emitted events so consumers know how a payload was extracted.
+	Kind() event.FramerKind
+}
+
+// detectFramer sniffs the first non-whitespace bytes of a session to pick a
+// Framer automatically. NDJSON has no distinguishing signature, so it is
+// only ever selected explicitly via SessionManager.SetFramer.
+func detectFramer(data []byte) (Framer, bool) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, false
+	}
+
+	if bytes.HasPrefix(trimmed, []byte(lspContentLengthHeader)) {
+		return &LSPFrame{}, true
+	}
+
+	return &JSONStream{}, true
+}
+
+// JSONStream frames whitespace-separated JSON values. This is MCPSpy's
+// original framing, used by most stdio-based MCP servers.
+type JSONStream struct{}
+
+// Kind implements Framer.
+func (f *JSONStream) Kind() event.FramerKind { return event.FramerKindJSONStream }
+
+// Feed implements Framer.
+func (f *JSONStream) Feed(data []byte) ([][]byte, []byte, error) {
+	bufData := bytes.TrimSpace(data)
+	if len(bufData) == 0 {
+		return nil, nil, nil
+	}
+
+	// Quick sanity check before expensive JSON parsing: must start with { or [
+	if bufData[0] != '{' && bufData[0] != '[' {
+		return nil, data, fmt.Errorf("invalid JSON start character: %c", bufData[0])
+	}
+
+	var frames [][]byte
+
+	// Use JSON decoder to parse multiple JSON objects
+	reader := bytes.NewReader(bufData)
+	decoder := json.NewDecoder(reader)
+	lastGoodPosition := int64(0)
+
+	for {
+		var jsonData json.RawMessage
+		if err := decoder.Decode(&jsonData); err != nil {
+			// io.EOF or a syntax/incomplete error - stop here and keep
+			// the remaining bytes for the next Feed call.
+			break
+		}
+
+		if len(bytes.TrimSpace(jsonData)) == 0 {
+			continue
+		}
+
+		frames = append(frames, bytes.Clone(jsonData))
+
+		// Track how many bytes we've successfully processed using
+		// InputOffset, which accounts for the decoder's internal buffering.
+		lastGoodPosition = decoder.InputOffset()
+	}
+
+	return frames, bufData[lastGoodPosition:], nil
+}
+
+// NDJSON frames strict one-message-per-line input. A malformed line is
+// dropped so a single corrupt record doesn't wedge the rest of the stream.
+type NDJSON struct{}
+
+// Kind implements Framer.
+func (f *NDJSON) Kind() event.FramerKind { return event.FramerKindNDJSON }
+
+// Feed implements Framer.
+func (f *NDJSON) Feed(data []byte) ([][]byte, []byte, error) {
+	var frames [][]byte
+
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			break
+		}
+
+		line := bytes.TrimSpace(data[:idx])
+		data = data[idx+1:]
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if !json.Valid(line) {
+			// Malformed line: drop it and recover on the next one.
+			continue
+		}
+
+		frames = append(frames, bytes.Clone(line))
+	}
+
+	return frames, data, nil
+}
+
+// LSPFrame frames the LSP base protocol (Content-Length: N\r\n\r\n<payload>)
+// used by MCP servers running over stdio or streams.
+type LSPFrame struct{}
+
+// Kind implements Framer.
+func (f *LSPFrame) Kind() event.FramerKind { return event.FramerKindLSP }
+
+// Feed implements Framer.
+func (f *LSPFrame) Feed(data []byte) ([][]byte, []byte, error) {
+	var frames [][]byte
+
+	for {
+		headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+		if headerEnd == -1 {
+			// Headers not fully received yet; wait for more data.
+			break
+		}
+
+		contentLength := -1
+		for _, header := range bytes.Split(data[:headerEnd], []byte("\r\n")) {
+			if !bytes.HasPrefix(header, []byte(lspContentLengthHeader)) {
+				continue
+			}
+
+			value := bytes.TrimSpace(header[len(lspContentLengthHeader):])
+			n, err := strconv.Atoi(string(value))
+			if err != nil {
+				return frames, data, fmt.Errorf("invalid Content-Length header %q: %w", value, err)
+			}
+			contentLength = n
+		}
+
+		if contentLength < 0 {
+			return frames, data, fmt.Errorf("LSP frame missing Content-Length header")
+		}
+
+		bodyStart := headerEnd + len("\r\n\r\n")
+		if len(data) < bodyStart+contentLength {
+			// Body not fully received yet; wait for more data.
+			break
+		}
+
+		frames = append(frames, bytes.Clone(data[bodyStart:bodyStart+contentLength]))
+		data = data[bodyStart+contentLength:]
+	}
+
+	return frames, data, nil
+}
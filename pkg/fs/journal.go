@@ -0,0 +1,198 @@
+package fs
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// defaultGapTimeout is how long a session waits for a missing offset range
+// to arrive before giving up and resyncing, when WithGapTimeout isn't set.
+const defaultGapTimeout = 2 * time.Second
+
+// journal reorders a session's out-of-order chunks by kernel offset before
+// they reach the framer. Concurrent readers, split probes, and NFS/overlayfs
+// reads can all deliver chunks for the same file out of arrival order;
+// session.buf must only ever see a contiguous prefix.
+type journal struct {
+	// nextOffset is the offset this session expects to flush next. A fresh
+	// session starts at 0, matching the kernel pos of a freshly opened fd.
+	nextOffset uint64
+
+	// pending holds chunks received ahead of nextOffset, keyed by their
+	// starting offset, waiting for the gap before them to fill in.
+	pending map[uint64][]byte
+
+	// gapSince is when pending first became non-empty after the last
+	// successful flush; zero when there's no open gap.
+	gapSince time.Time
+
+	// arrivalOrder is set once a chunk is observed that makes the kernel
+	// offset untrustworthy for this session (most notably: pipes and other
+	// non-seekable fds, which is how MCP stdio traffic actually arrives,
+	// report a `pos` that never advances). Once set, ingest stops
+	// reordering and falls back to the baseline behavior of appending
+	// chunks in arrival order.
+	arrivalOrder bool
+
+	// lastInputOffset is the raw offset of the previous chunk ingest was
+	// given, before any overlap clamping. Used to detect a kernel pos that
+	// isn't advancing, as opposed to a legitimate overlapping retransmit
+	// (which arrives at a later raw offset than the one before it).
+	lastInputOffset uint64
+	haveLastInput   bool
+}
+
+// ingest records a chunk at the given offset and returns the bytes that can
+// now be flushed, in order, onto the session buffer.
+func (j *journal) ingest(offset uint64, data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if j.arrivalOrder {
+		j.nextOffset += uint64(len(data))
+		return data
+	}
+
+	rawOffset := offset
+
+	if offset < j.nextOffset {
+		if j.haveLastInput && rawOffset <= j.lastInputOffset {
+			// The offset didn't advance from the previous chunk at all,
+			// even though this data arrived after bytes we already
+			// flushed: the kernel pos for this fd isn't a reliable stream
+			// position (e.g. a pipe or stdio fd, always reported at a
+			// fixed offset). Trust arrival order from here on instead of
+			// clamping or discarding the chunk - it may be a brand new,
+			// larger message than anything flushed so far.
+			j.arrivalOrder = true
+			flushed := j.drainPending()
+			flushed = append(flushed, data...)
+			j.nextOffset += uint64(len(data))
+			j.lastInputOffset = rawOffset
+			j.haveLastInput = true
+			return flushed
+		}
+
+		// Chunk overlaps data we've already flushed; keep only the tail.
+		skip := j.nextOffset - offset
+		if skip >= uint64(len(data)) {
+			j.lastInputOffset = rawOffset
+			j.haveLastInput = true
+			return nil
+		}
+		data = data[skip:]
+		offset = j.nextOffset
+	}
+
+	j.lastInputOffset = rawOffset
+	j.haveLastInput = true
+
+	if j.pending == nil {
+		j.pending = make(map[uint64][]byte)
+	}
+	j.pending[offset] = data
+
+	return j.flushContiguous()
+}
+
+// pendingLen returns the total bytes currently buffered in pending, waiting
+// on a gap to fill in. Counted toward a session's buffer cap alongside
+// sess.buf, since an ever-advancing offset with a permanent gap at
+// nextOffset would otherwise grow pending without bound.
+func (j *journal) pendingLen() int {
+	n := 0
+	for _, chunk := range j.pending {
+		n += len(chunk)
+	}
+	return n
+}
+
+// drainPending returns every still-buffered chunk concatenated in offset
+// order and clears pending, without requiring the gap before them to fill
+// in. Used when falling back to arrival-order mode, so chunks already
+// buffered ahead of nextOffset aren't silently lost.
+func (j *journal) drainPending() []byte {
+	if len(j.pending) == 0 {
+		return nil
+	}
+
+	offsets := make([]uint64, 0, len(j.pending))
+	for o := range j.pending {
+		offsets = append(offsets, o)
+	}
+	sort.Slice(offsets, func(a, b int) bool { return offsets[a] < offsets[b] })
+
+	var drained []byte
+	for _, o := range offsets {
+		drained = append(drained, j.pending[o]...)
+	}
+
+	j.pending = nil
+	j.gapSince = time.Time{}
+
+	return drained
+}
+
+// flushContiguous pulls the contiguous run of chunks starting at nextOffset
+// out of pending and returns them concatenated in order.
+func (j *journal) flushContiguous() []byte {
+	var flushed []byte
+
+	for {
+		chunk, ok := j.pending[j.nextOffset]
+		if !ok {
+			break
+		}
+
+		delete(j.pending, j.nextOffset)
+		flushed = append(flushed, chunk...)
+		j.nextOffset += uint64(len(chunk))
+	}
+
+	if len(j.pending) == 0 {
+		j.gapSince = time.Time{}
+	} else if j.gapSince.IsZero() {
+		j.gapSince = time.Now()
+	}
+
+	return flushed
+}
+
+// gapExpired reports whether a gap has been open for at least timeout.
+func (j *journal) gapExpired(timeout time.Time) bool {
+	return !j.gapSince.IsZero() && j.gapSince.Before(timeout)
+}
+
+// resync discards the unresolved gap and resumes from the next '{' or '['
+// found in the pending chunks, in offset order. It returns the offset it
+// resynced to, or false if no JSON start was found (in which case all
+// pending data is dropped).
+func (j *journal) resync() (uint64, bool) {
+	offsets := make([]uint64, 0, len(j.pending))
+	for o := range j.pending {
+		offsets = append(offsets, o)
+	}
+	sort.Slice(offsets, func(a, b int) bool { return offsets[a] < offsets[b] })
+
+	for _, o := range offsets {
+		chunk := j.pending[o]
+		delete(j.pending, o)
+
+		idx := bytes.IndexAny(chunk, "{[")
+		if idx == -1 {
+			continue
+		}
+
+		newOffset := o + uint64(idx)
+		j.nextOffset = newOffset
+		j.pending[newOffset] = chunk[idx:]
+		j.gapSince = time.Time{}
+		return newOffset, true
+	}
+
+	j.pending = make(map[uint64][]byte)
+	j.gapSince = time.Time{}
+	return 0, false
+}
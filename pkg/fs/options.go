@@ -0,0 +1,54 @@
+package fs
+
+import "time"
+
+// Option configures a SessionManager at construction time.
+type Option func(*SessionManager)
+
+// WithMaxBufferBytes bounds how large a session's unparsed buffer may grow.
+// If a session accumulates more than n bytes without producing a complete
+// frame, the buffer is dropped and an EventTypeFSSessionDropped event is
+// emitted. This guards against a peer that never completes a frame, e.g.
+// feeding a partial "{" forever.
+func WithMaxBufferBytes(n int) Option {
+	return func(s *SessionManager) {
+		s.maxBufferBytes = n
+	}
+}
+
+// WithSessionIdleTTL evicts sessions that haven't received an event for at
+// least d, freeing memory held by abandoned file descriptors.
+func WithSessionIdleTTL(d time.Duration) Option {
+	return func(s *SessionManager) {
+		s.sessionIdleTTL = d
+	}
+}
+
+// WithMaxSessions bounds the number of concurrently tracked sessions,
+// evicting the least recently updated session once the limit would be
+// exceeded.
+func WithMaxSessions(n int) Option {
+	return func(s *SessionManager) {
+		s.maxSessions = n
+	}
+}
+
+// WithSpillDir enables durable spilling: events that can't be delivered
+// because FSEvents is full are written to rotating NDJSON segments under
+// dir instead of being dropped. Spilled events can later be replayed with
+// Replay and compacted with Ack.
+func WithSpillDir(dir string) Option {
+	return func(s *SessionManager) {
+		s.spillDir = dir
+	}
+}
+
+// WithGapTimeout overrides how long a session waits for a missing offset
+// range to arrive before it emits an EventTypeFSJsonGap diagnostic and
+// resyncs by scanning forward to the next JSON value. Defaults to
+// defaultGapTimeout.
+func WithGapTimeout(d time.Duration) Option {
+	return func(s *SessionManager) {
+		s.gapTimeout = d
+	}
+}
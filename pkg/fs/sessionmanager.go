@@ -2,10 +2,9 @@ package fs
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"sync"
+	"time"
 
 	"github.com/alex-ilgayev/mcpspy/pkg/event"
 )
@@ -25,6 +24,20 @@ type session struct {
 
 	// Buffer for accumulating data
 	buf *bytes.Buffer
+
+	// framer is the sticky Framer for this session, detected from the
+	// first non-whitespace bytes observed (or set explicitly via
+	// SetFramer) and not re-sniffed afterwards, unless the session's
+	// buffer is dropped for exceeding maxBufferBytes.
+	framer Framer
+
+	// journal reorders chunks by kernel offset before they reach buf.
+	journal journal
+
+	// lastUpdate is when the session last received an FSDataEvent (even
+	// one whose bytes are still held in journal), used for idle reaping
+	// and LRU eviction.
+	lastUpdate time.Time
 }
 
 // SessionManager manages filesystem sessions and aggregates JSON payloads
@@ -33,14 +46,67 @@ type SessionManager struct {
 
 	sessions map[sessionKey]*session
 	eventCh  chan event.Event
+
+	maxBufferBytes int
+	sessionIdleTTL time.Duration
+	maxSessions    int
+	gapTimeout     time.Duration
+	spillDir       string
+
+	// spill durably holds events that couldn't be delivered because
+	// eventCh was full, when WithSpillDir is set.
+	spill *spool
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
 }
 
-// NewSessionManager creates a new filesystem session manager
-func NewSessionManager() *SessionManager {
-	return &SessionManager{
-		sessions: make(map[sessionKey]*session),
-		eventCh:  make(chan event.Event, 100),
+// NewSessionManager creates a new filesystem session manager. By default
+// sessions are unbounded; use the With* options to cap memory usage.
+func NewSessionManager(opts ...Option) *SessionManager {
+	s := &SessionManager{
+		sessions:   make(map[sessionKey]*session),
+		eventCh:    make(chan event.Event, 100),
+		stopCh:     make(chan struct{}),
+		gapTimeout: defaultGapTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.spillDir != "" {
+		if sp, err := newSpool(s.spillDir); err == nil {
+			s.spill = sp
+		}
 	}
+
+	if s.sessionIdleTTL > 0 {
+		s.wg.Add(1)
+		go s.reapIdleSessions()
+	}
+
+	return s
+}
+
+// deliver sends evt on eventCh if there's room. If the channel is full and
+// WithSpillDir is configured, evt is durably spilled to disk instead of
+// being dropped; Replay can later re-read it.
+func (s *SessionManager) deliver(evt event.Event) error {
+	select {
+	case s.eventCh <- evt:
+		return nil
+	default:
+	}
+
+	if s.spill != nil {
+		if err := s.spill.write(evt); err != nil {
+			return fmt.Errorf("FS event channel full and spill failed: %w", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("FS event channel is full, dropping FS JSON event")
 }
 
 // ProcessFSEvent processes filesystem read/write events and aggregates JSON payloads
@@ -58,6 +124,10 @@ func (s *SessionManager) ProcessFSEvent(e *event.FSDataEvent) error {
 	// Get or create session
 	sess, exists := s.sessions[key]
 	if !exists {
+		if s.maxSessions > 0 && len(s.sessions) >= s.maxSessions {
+			s.evictOldestLocked()
+		}
+
 		sess = &session{
 			pid:     e.PID,
 			comm:    e.CommBytes,
@@ -67,69 +137,190 @@ func (s *SessionManager) ProcessFSEvent(e *event.FSDataEvent) error {
 		s.sessions[key] = sess
 	}
 
-	// Append data to buffer
-	if _, err := sess.buf.Write(e.Buffer()); err != nil {
-		return err
+	sess.lastUpdate = time.Now()
+
+	// Reorder this chunk against the session's per-file offset before it
+	// reaches the framer; only a contiguous prefix is ever appended to buf.
+	if flushed := sess.journal.ingest(e.Offset, e.Buffer()); len(flushed) > 0 {
+		if _, err := sess.buf.Write(flushed); err != nil {
+			return err
+		}
 	}
 
-	// Try to parse JSON from the accumulated buffer
-	if err := s.tryEmitJsonEvent(sess, key); err != nil {
-		return err
+	if sess.journal.gapExpired(time.Now().Add(-s.gapTimeout)) {
+		if err := s.emitJsonGapLocked(sess); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	// Try to parse JSON from the accumulated buffer. A framer error (e.g.
+	// a framer that rejects its leading byte outright) leaves sess.buf
+	// untouched, so the buffer cap below must still run on this path -
+	// otherwise a session that never frames can grow unbounded.
+	emitErr := s.tryEmitJsonEvent(sess, key)
+
+	// Bail out of a session that never produces a complete frame (e.g. an
+	// attacker feeding a partial "{" forever) before its buffer grows
+	// without bound. journal.pending counts too: a permanent gap at
+	// nextOffset (chunks arriving at ever-increasing offsets that never
+	// connect to it) accumulates there instead of in sess.buf.
+	if s.maxBufferBytes > 0 && sess.buf.Len()+sess.journal.pendingLen() > s.maxBufferBytes {
+		s.emitSessionDroppedLocked(sess, "buffer_limit_exceeded")
+		sess.buf.Reset()
+		sess.framer = nil
+		sess.journal = journal{}
+	}
+
+	return emitErr
 }
 
-// tryEmitJsonEvent attempts to parse and emit complete JSON messages
-func (s *SessionManager) tryEmitJsonEvent(sess *session, key sessionKey) error {
-	bufData := bytes.TrimSpace(sess.buf.Bytes())
-	if len(bufData) == 0 {
-		sess.buf.Reset()
-		return nil
+// evictOldestLocked removes the least recently updated session to make room
+// under WithMaxSessions. Callers must hold s.mu.
+func (s *SessionManager) evictOldestLocked() {
+	var oldestKey sessionKey
+	var oldest *session
+
+	for key, sess := range s.sessions {
+		if oldest == nil || sess.lastUpdate.Before(oldest.lastUpdate) {
+			oldestKey, oldest = key, sess
+		}
 	}
 
-	// Quick sanity check before expensive JSON parsing: must start with { or [
-	if bufData[0] != '{' && bufData[0] != '[' {
-		return fmt.Errorf("invalid JSON start character: %c", bufData[0])
+	if oldest == nil {
+		return
 	}
 
-	// Use JSON decoder to parse multiple JSON objects
-	reader := bytes.NewReader(bufData)
-	decoder := json.NewDecoder(reader)
-	lastGoodPosition := int64(0)
+	s.emitSessionDroppedLocked(oldest, "max_sessions_exceeded")
+	delete(s.sessions, oldestKey)
+}
+
+// reapIdleSessions periodically evicts sessions that haven't been appended
+// to in at least sessionIdleTTL, freeing buffers held by abandoned file
+// descriptors.
+func (s *SessionManager) reapIdleSessions() {
+	defer s.wg.Done()
+
+	interval := s.sessionIdleTTL / 4
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
 	for {
-		var jsonData json.RawMessage
-		err := decoder.Decode(&jsonData)
-		if err != nil {
-			if err == io.EOF {
-				// Successfully processed all complete JSON objects
-				break
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.reapOnce()
+		}
+	}
+}
+
+// reapOnce sweeps sessions idle for longer than sessionIdleTTL.
+func (s *SessionManager) reapOnce() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, sess := range s.sessions {
+		if now.Sub(sess.lastUpdate) >= s.sessionIdleTTL {
+			s.emitSessionDroppedLocked(sess, "idle_ttl_expired")
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// emitSessionDroppedLocked emits an EventTypeFSSessionDropped event for a
+// session being evicted. Callers must hold s.mu.
+func (s *SessionManager) emitSessionDroppedLocked(sess *session, reason string) {
+	evt := &event.FSSessionDroppedEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSSessionDropped,
+			PID:       sess.pid,
+			CommBytes: sess.comm,
+		},
+		FilePtr: sess.filePtr,
+		Reason:  reason,
+	}
+
+	_ = s.deliver(evt)
+}
+
+// emitJsonGapLocked emits an EventTypeFSJsonGap diagnostic for a session
+// whose offset gap went unresolved for longer than gapTimeout, then resyncs
+// the journal by scanning forward to the next JSON value start. Callers
+// must hold s.mu.
+func (s *SessionManager) emitJsonGapLocked(sess *session) error {
+	evt := &event.FSJsonGapEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSJsonGap,
+			PID:       sess.pid,
+			CommBytes: sess.comm,
+		},
+		FilePtr: sess.filePtr,
+		Offset:  sess.journal.nextOffset,
+	}
+
+	_ = s.deliver(evt)
+
+	if _, ok := sess.journal.resync(); ok {
+		if flushed := sess.journal.flushContiguous(); len(flushed) > 0 {
+			if _, err := sess.buf.Write(flushed); err != nil {
+				return err
 			}
-			// Syntax error or incomplete JSON - stop here and keep remaining bytes
-			break
 		}
+	}
+
+	return nil
+}
+
+// SetFramer overrides the framer used for a session, bypassing
+// auto-detection. Useful when the caller already knows a transport's
+// framing ahead of time (e.g. an NDJSON-based exporter).
+func (s *SessionManager) SetFramer(key sessionKey, f Framer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		if len(bytes.TrimSpace(jsonData)) == 0 {
-			continue
+	sess, exists := s.sessions[key]
+	if !exists {
+		sess = &session{
+			pid:     key.pid,
+			filePtr: key.filePtr,
+			buf:     &bytes.Buffer{},
 		}
+		s.sessions[key] = sess
+	}
+	sess.framer = f
+}
 
-		// Emit this JSON message
-		if err := s.emitJsonEvent(sess, key, jsonData); err != nil {
-			return err
+// tryEmitJsonEvent feeds the accumulated session buffer through the
+// session's framer (auto-detecting one on first use), emitting every
+// complete frame it returns.
+func (s *SessionManager) tryEmitJsonEvent(sess *session, key sessionKey) error {
+	if sess.framer == nil {
+		framer, ok := detectFramer(sess.buf.Bytes())
+		if !ok {
+			// Not enough data yet to sniff a framing mode.
+			return nil
 		}
+		sess.framer = framer
+	}
 
-		// Track how many bytes we've successfully processed using InputOffset
-		// which accounts for decoder's internal buffering
-		lastGoodPosition = decoder.InputOffset()
+	frames, remaining, err := sess.framer.Feed(sess.buf.Bytes())
+	if err != nil {
+		return err
 	}
 
-	// Update buffer: keep only unprocessed bytes
-	if lastGoodPosition > 0 {
-		remainingData := bufData[lastGoodPosition:]
-		sess.buf = bytes.NewBuffer(remainingData)
+	for _, frame := range frames {
+		if err := s.emitJsonEvent(sess, key, frame); err != nil {
+			return err
+		}
 	}
 
+	sess.buf = bytes.NewBuffer(remaining)
+
 	return nil
 }
 
@@ -140,7 +331,7 @@ func (s *SessionManager) emitJsonEvent(sess *session, key sessionKey, payload []
 		newEventType = event.EventTypeFSJsonWrite
 	}
 
-	event := event.FSJsonEvent{
+	evt := &event.FSJsonEvent{
 		EventHeader: event.EventHeader{
 			EventType: newEventType,
 			PID:       sess.pid,
@@ -148,15 +339,10 @@ func (s *SessionManager) emitJsonEvent(sess *session, key sessionKey, payload []
 		},
 		FilePtr: sess.filePtr,
 		Payload: payload,
+		Framer:  sess.framer.Kind(),
 	}
 
-	select {
-	case s.eventCh <- &event:
-	default:
-		return fmt.Errorf("FS event channel is full, dropping FS JSON event")
-	}
-
-	return nil
+	return s.deliver(evt)
 }
 
 // FSEvents returns a channel for receiving filesystem JSON events
@@ -164,13 +350,21 @@ func (s *SessionManager) FSEvents() <-chan event.Event {
 	return s.eventCh
 }
 
-// Close closes the event channel and cleans up sessions
+// Close stops the idle reaper, finalizes any open spill segment, closes
+// the event channel, and cleans up sessions.
 func (s *SessionManager) Close() {
+	close(s.stopCh)
+	s.wg.Wait()
+
 	close(s.eventCh)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.spill != nil {
+		_ = s.spill.close()
+	}
+
 	// Clear all sessions
 	s.sessions = make(map[sessionKey]*session)
 }
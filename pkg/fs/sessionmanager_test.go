@@ -1,6 +1,9 @@
 package fs
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -69,6 +72,7 @@ func TestSessionManager_FragmentedJson(t *testing.T) {
 			PID:       pid,
 		},
 		FilePtr: filePtr,
+		Offset:  0,
 		BufSize: uint32(len(fragment1)),
 	}
 	copy(event1.Buf[:], fragment1)
@@ -89,6 +93,7 @@ func TestSessionManager_FragmentedJson(t *testing.T) {
 			PID:       pid,
 		},
 		FilePtr: filePtr,
+		Offset:  uint64(len(fragment1)),
 		BufSize: uint32(len(fragment2)),
 	}
 	copy(event2.Buf[:], fragment2)
@@ -109,6 +114,7 @@ func TestSessionManager_FragmentedJson(t *testing.T) {
 			PID:       pid,
 		},
 		FilePtr: filePtr,
+		Offset:  uint64(len(fragment1) + len(fragment2)),
 		BufSize: uint32(len(fragment3)),
 	}
 	copy(event3.Buf[:], fragment3)
@@ -191,6 +197,7 @@ func TestSessionManager_MultipleJsonAcrossFragments(t *testing.T) {
 			PID:       pid,
 		},
 		FilePtr: filePtr,
+		Offset:  0,
 		BufSize: uint32(len(event1Data)),
 	}
 	copy(event1.Buf[:], event1Data)
@@ -216,6 +223,7 @@ func TestSessionManager_MultipleJsonAcrossFragments(t *testing.T) {
 			PID:       pid,
 		},
 		FilePtr: filePtr,
+		Offset:  uint64(len(event1Data)),
 		BufSize: uint32(len(event2Data)),
 	}
 	copy(event2.Buf[:], event2Data)
@@ -567,3 +575,762 @@ func TestSessionManager_ReadWriteEventTypes(t *testing.T) {
 		t.Fatal("Did not receive write event")
 	}
 }
+
+func TestSessionManager_LSPFraming(t *testing.T) {
+	sm := NewSessionManager()
+	defer sm.Close()
+
+	pid := uint32(2020)
+	filePtr := uint64(0x9999)
+
+	payload := `{"jsonrpc":"2.0","method":"initialize","id":1}`
+	frame := []byte(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(payload), payload))
+
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		BufSize: uint32(len(frame)),
+	}
+	copy(fsEvent.Buf[:], frame)
+
+	if err := sm.ProcessFSEvent(fsEvent); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != payload {
+			t.Errorf("Expected payload %q, got %q", payload, fsJsonEvt.Payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive FSJsonEvent for LSP frame")
+	}
+}
+
+func TestSessionManager_LSPFramingPartialHeader(t *testing.T) {
+	sm := NewSessionManager()
+	defer sm.Close()
+
+	pid := uint32(2021)
+	filePtr := uint64(0x9998)
+
+	payload := `{"jsonrpc":"2.0","method":"ping"}`
+	frame := []byte(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(payload), payload))
+
+	// Split mid-header so the session must retain the partial header across fragments.
+	split := len("Content-Length: 3")
+	fragment1 := frame[:split]
+	fragment2 := frame[split:]
+
+	event1 := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSWrite,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  0,
+		BufSize: uint32(len(fragment1)),
+	}
+	copy(event1.Buf[:], fragment1)
+	if err := sm.ProcessFSEvent(event1); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case <-sm.FSEvents():
+		t.Fatal("Should not emit event for partial LSP header")
+	case <-time.After(50 * time.Millisecond):
+		// Expected - no event yet
+	}
+
+	event2 := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSWrite,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  uint64(len(fragment1)),
+		BufSize: uint32(len(fragment2)),
+	}
+	copy(event2.Buf[:], fragment2)
+	if err := sm.ProcessFSEvent(event2); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != payload {
+			t.Errorf("Expected payload %q, got %q", payload, fsJsonEvt.Payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive FSJsonEvent after completing LSP header")
+	}
+}
+
+func TestSessionManager_FramerKindMetadata(t *testing.T) {
+	sm := NewSessionManager()
+	defer sm.Close()
+
+	jsonData := []byte(`{"id":1}`)
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       3030,
+		},
+		FilePtr: 0xa0a0,
+		BufSize: uint32(len(jsonData)),
+	}
+	copy(fsEvent.Buf[:], jsonData)
+
+	if err := sm.ProcessFSEvent(fsEvent); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if fsJsonEvt.Framer != event.FramerKindJSONStream {
+			t.Errorf("Expected FramerKindJSONStream, got %v", fsJsonEvt.Framer)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive FSJsonEvent")
+	}
+}
+
+func TestSessionManager_SetFramerNDJSON(t *testing.T) {
+	sm := NewSessionManager()
+	defer sm.Close()
+
+	pid := uint32(3031)
+	filePtr := uint64(0xa0a1)
+	key := sessionKey{pid: pid, filePtr: filePtr, origEventType: event.EventTypeFSRead}
+	sm.SetFramer(key, &NDJSON{})
+
+	// NDJSON requires a trailing newline per record; without SetFramer this
+	// would be auto-detected as JSONStream and tagged FramerKindJSONStream.
+	ndjsonData := []byte("{\"id\":1}\n{\"id\":2}\n")
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		BufSize: uint32(len(ndjsonData)),
+	}
+	copy(fsEvent.Buf[:], ndjsonData)
+
+	if err := sm.ProcessFSEvent(fsEvent); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	expectedPayloads := []string{`{"id":1}`, `{"id":2}`}
+	for i, expected := range expectedPayloads {
+		select {
+		case evt := <-sm.FSEvents():
+			fsJsonEvt := evt.(*event.FSJsonEvent)
+			if string(fsJsonEvt.Payload) != expected {
+				t.Errorf("Event %d: expected %q, got %q", i, expected, fsJsonEvt.Payload)
+			}
+			if fsJsonEvt.Framer != event.FramerKindNDJSON {
+				t.Errorf("Event %d: expected FramerKindNDJSON, got %v", i, fsJsonEvt.Framer)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("Did not receive NDJSON event %d", i)
+		}
+	}
+}
+
+func TestSessionManager_NDJSONRecoversFromMalformedLine(t *testing.T) {
+	f := &NDJSON{}
+
+	data := []byte("{\"id\":1}\nnot json\n{\"id\":2}\n")
+	frames, remaining, err := f.Feed(data)
+	if err != nil {
+		t.Fatalf("Feed failed: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Expected no remaining bytes, got %q", remaining)
+	}
+
+	expected := []string{`{"id":1}`, `{"id":2}`}
+	if len(frames) != len(expected) {
+		t.Fatalf("Expected %d frames, got %d", len(expected), len(frames))
+	}
+	for i, want := range expected {
+		if string(frames[i]) != want {
+			t.Errorf("Frame %d: expected %q, got %q", i, want, frames[i])
+		}
+	}
+}
+
+func TestSessionManager_MaxBufferBytesDropsSession(t *testing.T) {
+	sm := NewSessionManager(WithMaxBufferBytes(8))
+	defer sm.Close()
+
+	// Never-completing JSON that exceeds the configured limit.
+	partial := []byte(`{"incomplete":"aaaaaaaaaaaaaaaaaaaa`)
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       4040,
+		},
+		FilePtr: 0xb0b0,
+		BufSize: uint32(len(partial)),
+	}
+	copy(fsEvent.Buf[:], partial)
+
+	if err := sm.ProcessFSEvent(fsEvent); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		if evt.Type() != event.EventTypeFSSessionDropped {
+			t.Fatalf("Expected EventTypeFSSessionDropped, got %v", evt.Type())
+		}
+		dropped := evt.(*event.FSSessionDroppedEvent)
+		if dropped.Reason != "buffer_limit_exceeded" {
+			t.Errorf("Expected reason buffer_limit_exceeded, got %q", dropped.Reason)
+		}
+		if dropped.PID != 4040 || dropped.FilePtr != 0xb0b0 {
+			t.Errorf("Unexpected PID/FilePtr on dropped event: %+v", dropped)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive EventTypeFSSessionDropped")
+	}
+
+	// Valid JSON arriving afterwards, within limits, should still succeed
+	// in a fresh session.
+	validJson := []byte(`{"ok":true}`)
+	fsEvent2 := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSWrite,
+			PID:       4041,
+		},
+		FilePtr: 0xb0b1,
+		BufSize: uint32(len(validJson)),
+	}
+	copy(fsEvent2.Buf[:], validJson)
+
+	if err := sm.ProcessFSEvent(fsEvent2); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != string(validJson) {
+			t.Errorf("Expected payload %q, got %q", validJson, fsJsonEvt.Payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive FSJsonEvent for valid JSON after a dropped session")
+	}
+}
+
+func TestSessionManager_MaxBufferBytesAppliesOnFramerError(t *testing.T) {
+	sm := NewSessionManager(WithMaxBufferBytes(8))
+	defer sm.Close()
+
+	// Leading byte the JSONStream framer rejects outright on every call, so
+	// tryEmitJsonEvent returns an error without ever touching sess.buf. The
+	// cap must still be enforced on this path instead of letting the
+	// session grow unbounded.
+	garbage := []byte("xxxxxxxxxxxxxxxxxxxxxxxxxx")
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       4242,
+		},
+		FilePtr: 0xb1b1,
+		BufSize: uint32(len(garbage)),
+	}
+	copy(fsEvent.Buf[:], garbage)
+
+	if err := sm.ProcessFSEvent(fsEvent); err == nil {
+		t.Fatal("Expected ProcessFSEvent to surface the framer error")
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		if evt.Type() != event.EventTypeFSSessionDropped {
+			t.Fatalf("Expected EventTypeFSSessionDropped, got %v", evt.Type())
+		}
+		dropped := evt.(*event.FSSessionDroppedEvent)
+		if dropped.Reason != "buffer_limit_exceeded" {
+			t.Errorf("Expected reason buffer_limit_exceeded, got %q", dropped.Reason)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive EventTypeFSSessionDropped for a session stuck on a framer error")
+	}
+}
+
+func TestSessionManager_MaxBufferBytesCountsJournalPending(t *testing.T) {
+	sm := NewSessionManager(WithMaxBufferBytes(8))
+	defer sm.Close()
+
+	// A chunk that starts well past nextOffset (a permanent gap at 0, e.g.
+	// a peer that never sends its first bytes) is held in journal.pending,
+	// not sess.buf. The cap must account for it there too, or a session
+	// stuck on an open gap grows pending without bound.
+	stuck := []byte("0123456789")
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       4343,
+		},
+		FilePtr: 0xb2b2,
+		Offset:  100,
+		BufSize: uint32(len(stuck)),
+	}
+	copy(fsEvent.Buf[:], stuck)
+
+	if err := sm.ProcessFSEvent(fsEvent); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		if evt.Type() != event.EventTypeFSSessionDropped {
+			t.Fatalf("Expected EventTypeFSSessionDropped, got %v", evt.Type())
+		}
+		dropped := evt.(*event.FSSessionDroppedEvent)
+		if dropped.Reason != "buffer_limit_exceeded" {
+			t.Errorf("Expected reason buffer_limit_exceeded, got %q", dropped.Reason)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive EventTypeFSSessionDropped for pending bytes stuck behind a gap")
+	}
+}
+
+func TestSessionManager_SessionIdleTTLReaping(t *testing.T) {
+	sm := NewSessionManager(WithSessionIdleTTL(20 * time.Millisecond))
+	defer sm.Close()
+
+	partial := []byte(`{"incomplete":`)
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       5050,
+		},
+		FilePtr: 0xc0c0,
+		BufSize: uint32(len(partial)),
+	}
+	copy(fsEvent.Buf[:], partial)
+
+	if err := sm.ProcessFSEvent(fsEvent); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		if evt.Type() != event.EventTypeFSSessionDropped {
+			t.Fatalf("Expected EventTypeFSSessionDropped, got %v", evt.Type())
+		}
+		dropped := evt.(*event.FSSessionDroppedEvent)
+		if dropped.Reason != "idle_ttl_expired" {
+			t.Errorf("Expected reason idle_ttl_expired, got %q", dropped.Reason)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Did not receive EventTypeFSSessionDropped for idle session")
+	}
+
+	sm.mu.Lock()
+	_, exists := sm.sessions[sessionKey{pid: 5050, filePtr: 0xc0c0, origEventType: event.EventTypeFSRead}]
+	sm.mu.Unlock()
+	if exists {
+		t.Fatal("Session should have been reaped")
+	}
+}
+
+func TestSessionManager_MaxSessionsEvictsOldest(t *testing.T) {
+	sm := NewSessionManager(WithMaxSessions(2))
+	defer sm.Close()
+
+	newPartial := func(pid uint32, filePtr uint64) *event.FSDataEvent {
+		data := []byte(`{"incomplete":`)
+		e := &event.FSDataEvent{
+			EventHeader: event.EventHeader{
+				EventType: event.EventTypeFSRead,
+				PID:       pid,
+			},
+			FilePtr: filePtr,
+			BufSize: uint32(len(data)),
+		}
+		copy(e.Buf[:], data)
+		return e
+	}
+
+	if err := sm.ProcessFSEvent(newPartial(6060, 0xd0d0)); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := sm.ProcessFSEvent(newPartial(6061, 0xd0d1)); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	// A third session should evict the first (oldest by lastUpdate).
+	if err := sm.ProcessFSEvent(newPartial(6062, 0xd0d2)); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		dropped := evt.(*event.FSSessionDroppedEvent)
+		if dropped.Reason != "max_sessions_exceeded" {
+			t.Errorf("Expected reason max_sessions_exceeded, got %q", dropped.Reason)
+		}
+		if dropped.PID != 6060 {
+			t.Errorf("Expected the oldest session (PID 6060) to be evicted, got PID %d", dropped.PID)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive EventTypeFSSessionDropped")
+	}
+
+	sm.mu.Lock()
+	sessionCount := len(sm.sessions)
+	sm.mu.Unlock()
+	if sessionCount != 2 {
+		t.Errorf("Expected 2 sessions after eviction, got %d", sessionCount)
+	}
+}
+
+func TestSessionManager_OutOfOrderOffsetsReorder(t *testing.T) {
+	sm := NewSessionManager()
+	defer sm.Close()
+
+	pid := uint32(7070)
+	filePtr := uint64(0xe0e0)
+
+	payload := []byte(`{"jsonrpc":"2.0","method":"test"}`)
+	first := payload[:10]
+	second := payload[10:]
+
+	// Second half arrives first, at its real kernel offset.
+	outOfOrder := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  uint64(len(first)),
+		BufSize: uint32(len(second)),
+	}
+	copy(outOfOrder.Buf[:], second)
+	if err := sm.ProcessFSEvent(outOfOrder); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case <-sm.FSEvents():
+		t.Fatal("Should not emit event before the gap at offset 0 is filled")
+	case <-time.After(50 * time.Millisecond):
+		// Expected - waiting on the missing prefix
+	}
+
+	// First half arrives late, filling the gap.
+	inOrder := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  0,
+		BufSize: uint32(len(first)),
+	}
+	copy(inOrder.Buf[:], first)
+	if err := sm.ProcessFSEvent(inOrder); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != string(payload) {
+			t.Errorf("Expected reordered payload %q, got %q", payload, fsJsonEvt.Payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive FSJsonEvent after gap was filled")
+	}
+}
+
+func TestSessionManager_StuckOffsetFallsBackToArrivalOrder(t *testing.T) {
+	sm := NewSessionManager()
+	defer sm.Close()
+
+	pid := uint32(7171)
+	filePtr := uint64(0xe1e1)
+
+	// Pipes and other non-seekable fds (MCPSpy's primary stdio transport)
+	// don't advance the kernel pos between reads, so every fragment is
+	// reported at the same Offset. The journal must still reassemble this
+	// in arrival order instead of treating later fragments as already-seen
+	// data and dropping them.
+	fragment1 := []byte(`{"jsonrpc":"2.0","me`)
+	fragment2 := []byte(`thod":"test"}`)
+
+	event1 := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  0,
+		BufSize: uint32(len(fragment1)),
+	}
+	copy(event1.Buf[:], fragment1)
+	if err := sm.ProcessFSEvent(event1); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	event2 := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  0,
+		BufSize: uint32(len(fragment2)),
+	}
+	copy(event2.Buf[:], fragment2)
+	if err := sm.ProcessFSEvent(event2); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	expectedJson := `{"jsonrpc":"2.0","method":"test"}`
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != expectedJson {
+			t.Errorf("Expected payload %q, got %q", expectedJson, fsJsonEvt.Payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive FSJsonEvent for fragments with a stuck offset")
+	}
+}
+
+func TestSessionManager_StuckOffsetHandlesLargerSubsequentMessage(t *testing.T) {
+	sm := NewSessionManager()
+	defer sm.Close()
+
+	pid := uint32(7172)
+	filePtr := uint64(0xe1e2)
+
+	// Two complete messages on the same stuck-at-0 offset, the second
+	// larger than the first. A size-based overlap check would wrongly
+	// treat the second as a partial overlap of the first and clamp its
+	// leading bytes away instead of recognizing the stuck offset.
+	first := []byte(`{"id":1}`)
+	second := []byte(`{"id":2,"method":"a-longer-message-than-the-first"}`)
+
+	event1 := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  0,
+		BufSize: uint32(len(first)),
+	}
+	copy(event1.Buf[:], first)
+	if err := sm.ProcessFSEvent(event1); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != string(first) {
+			t.Errorf("Expected first payload %q, got %q", first, fsJsonEvt.Payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive first FSJsonEvent")
+	}
+
+	event2 := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  0,
+		BufSize: uint32(len(second)),
+	}
+	copy(event2.Buf[:], second)
+	if err := sm.ProcessFSEvent(event2); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != string(second) {
+			t.Errorf("Expected second payload %q, got %q", second, fsJsonEvt.Payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive second FSJsonEvent for a larger message at a stuck offset")
+	}
+}
+
+func TestSessionManager_GapTimeoutResyncs(t *testing.T) {
+	sm := NewSessionManager(WithGapTimeout(10 * time.Millisecond))
+	defer sm.Close()
+
+	pid := uint32(7071)
+	filePtr := uint64(0xe0e1)
+
+	// This chunk starts at offset 100, leaving a gap at [0, 100) that will
+	// never be filled.
+	garbageLen := 5
+	payload := []byte(`{"id":1}`)
+	chunk := append(bytes.Repeat([]byte("x"), garbageLen), payload...)
+
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  100,
+		BufSize: uint32(len(chunk)),
+	}
+	copy(fsEvent.Buf[:], chunk)
+	if err := sm.ProcessFSEvent(fsEvent); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case <-sm.FSEvents():
+		t.Fatal("Should not emit event while the gap is still open")
+	case <-time.After(20 * time.Millisecond):
+		// Expected - gap not yet timed out
+	}
+
+	// Nudge the session so it re-checks the gap timeout and resyncs.
+	nudge := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Offset:  uint64(100 + len(chunk)),
+		BufSize: 0,
+	}
+	if err := sm.ProcessFSEvent(nudge); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		if evt.Type() != event.EventTypeFSJsonGap {
+			t.Fatalf("Expected EventTypeFSJsonGap, got %v", evt.Type())
+		}
+		gap := evt.(*event.FSJsonGapEvent)
+		if gap.Offset != 0 {
+			t.Errorf("Expected gap offset 0 (the missing prefix), got %d", gap.Offset)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive EventTypeFSJsonGap")
+	}
+
+	select {
+	case evt := <-sm.FSEvents():
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != string(payload) {
+			t.Errorf("Expected resynced payload %q, got %q", payload, fsJsonEvt.Payload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not receive FSJsonEvent after resync")
+	}
+}
+
+func TestSessionManager_SpillsWhenChannelFullAndReplays(t *testing.T) {
+	spillDir := t.TempDir()
+	sm := NewSessionManager(WithSpillDir(spillDir))
+	defer sm.Close()
+
+	// Fill FSEvents' buffered capacity (100) without draining it, so the
+	// next event has nowhere to go but the spill directory.
+	for i := 0; i < 100; i++ {
+		jsonData := []byte(fmt.Sprintf(`{"id":%d}`, i))
+		fsEvent := &event.FSDataEvent{
+			EventHeader: event.EventHeader{
+				EventType: event.EventTypeFSRead,
+				PID:       8080,
+			},
+			FilePtr: uint64(i), // distinct session per message to avoid offset reuse
+			BufSize: uint32(len(jsonData)),
+		}
+		copy(fsEvent.Buf[:], jsonData)
+		if err := sm.ProcessFSEvent(fsEvent); err != nil {
+			t.Fatalf("ProcessFSEvent failed: %v", err)
+		}
+	}
+
+	overflow := []byte(`{"id":"overflow"}`)
+	fsEvent := &event.FSDataEvent{
+		EventHeader: event.EventHeader{
+			EventType: event.EventTypeFSRead,
+			PID:       8080,
+		},
+		FilePtr: 1000,
+		BufSize: uint32(len(overflow)),
+	}
+	copy(fsEvent.Buf[:], overflow)
+	if err := sm.ProcessFSEvent(fsEvent); err != nil {
+		t.Fatalf("ProcessFSEvent failed: %v", err)
+	}
+
+	// Drain the 100 buffered events so the replayed one doesn't get
+	// confused with them.
+	for i := 0; i < 100; i++ {
+		<-sm.FSEvents()
+	}
+
+	ids, err := sm.Segments()
+	if err != nil {
+		t.Fatalf("Segments failed: %v", err)
+	}
+	if len(ids) == 0 {
+		t.Fatal("Expected at least one spill segment")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	replayed, err := sm.Replay(ctx, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	select {
+	case evt, ok := <-replayed:
+		if !ok {
+			t.Fatal("Replay channel closed before yielding the spilled event")
+		}
+		fsJsonEvt := evt.(*event.FSJsonEvent)
+		if string(fsJsonEvt.Payload) != string(overflow) {
+			t.Errorf("Expected replayed payload %q, got %q", overflow, fsJsonEvt.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive replayed event")
+	}
+
+	for _, id := range ids {
+		if err := sm.Ack(id); err != nil {
+			t.Fatalf("Ack failed: %v", err)
+		}
+	}
+
+	remaining, err := sm.Segments()
+	if err != nil {
+		t.Fatalf("Segments failed: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Expected no segments after Ack, got %d", len(remaining))
+	}
+}
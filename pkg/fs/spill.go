@@ -0,0 +1,315 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alex-ilgayev/mcpspy/pkg/event"
+)
+
+// maxSegmentBytes is the approximate size at which a spill segment is
+// rotated and a new one started.
+const maxSegmentBytes = 4 << 20 // 4MB
+
+// segmentCompleteMarker is appended as the final line of a segment once
+// it's rotated out or the spool is closed, marking it as fully written.
+// Replay skips this line and replays segments with or without it, including
+// the one still being appended to.
+const segmentCompleteMarker = `{"complete":true}`
+
+// spillRecord is the on-disk NDJSON representation of a spilled event.
+type spillRecord struct {
+	EventType event.EventType  `json:"event_type"`
+	Timestamp int64            `json:"ts"`
+	PID       uint32           `json:"pid"`
+	Comm      [16]uint8        `json:"comm"`
+	FilePtr   uint64           `json:"file_ptr,omitempty"`
+	Payload   []byte           `json:"payload,omitempty"`
+	Framer    event.FramerKind `json:"framer,omitempty"`
+	Reason    string           `json:"reason,omitempty"`
+	Offset    uint64           `json:"offset,omitempty"`
+}
+
+// encodeRecord converts an event into its spillable form. ok is false for
+// event types that aren't worth persisting to the replay log.
+func encodeRecord(evt event.Event) (rec spillRecord, ok bool) {
+	switch e := evt.(type) {
+	case *event.FSJsonEvent:
+		return spillRecord{
+			EventType: e.EventType,
+			PID:       e.PID,
+			Comm:      e.CommBytes,
+			FilePtr:   e.FilePtr,
+			Payload:   e.Payload,
+			Framer:    e.Framer,
+		}, true
+	case *event.FSSessionDroppedEvent:
+		return spillRecord{
+			EventType: e.EventType,
+			PID:       e.PID,
+			Comm:      e.CommBytes,
+			FilePtr:   e.FilePtr,
+			Reason:    e.Reason,
+		}, true
+	case *event.FSJsonGapEvent:
+		return spillRecord{
+			EventType: e.EventType,
+			PID:       e.PID,
+			Comm:      e.CommBytes,
+			FilePtr:   e.FilePtr,
+			Offset:    e.Offset,
+		}, true
+	default:
+		return spillRecord{}, false
+	}
+}
+
+// decodeRecord reconstructs the event a spillRecord was encoded from.
+func decodeRecord(rec spillRecord) event.Event {
+	header := event.EventHeader{
+		EventType: rec.EventType,
+		PID:       rec.PID,
+		CommBytes: rec.Comm,
+	}
+
+	switch rec.EventType {
+	case event.EventTypeFSJsonRead, event.EventTypeFSJsonWrite:
+		return &event.FSJsonEvent{
+			EventHeader: header,
+			FilePtr:     rec.FilePtr,
+			Payload:     rec.Payload,
+			Framer:      rec.Framer,
+		}
+	case event.EventTypeFSSessionDropped:
+		return &event.FSSessionDroppedEvent{
+			EventHeader: header,
+			FilePtr:     rec.FilePtr,
+			Reason:      rec.Reason,
+		}
+	case event.EventTypeFSJsonGap:
+		return &event.FSJsonGapEvent{
+			EventHeader: header,
+			FilePtr:     rec.FilePtr,
+			Offset:      rec.Offset,
+		}
+	default:
+		return nil
+	}
+}
+
+// spool is a rotating, append-only set of NDJSON segment files under a
+// directory, used to durably hold events the consumer couldn't keep up
+// with instead of dropping them.
+type spool struct {
+	dir string
+
+	mu      sync.Mutex
+	current *os.File
+	written int
+}
+
+func newSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spill directory: %w", err)
+	}
+	return &spool{dir: dir}, nil
+}
+
+// write appends an event to the active segment, rotating to a new segment
+// if the active one has grown past maxSegmentBytes.
+func (sp *spool) write(evt event.Event) error {
+	rec, ok := encodeRecord(evt)
+	if !ok {
+		return nil
+	}
+	rec.Timestamp = time.Now().UnixNano()
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.current == nil {
+		if err := sp.openSegmentLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := sp.current.Write(line)
+	if err != nil {
+		return err
+	}
+	sp.written += n
+
+	if sp.written >= maxSegmentBytes {
+		return sp.rotateLocked()
+	}
+
+	return nil
+}
+
+func (sp *spool) openSegmentLocked() error {
+	name := fmt.Sprintf("segment-%d.ndjson", time.Now().UnixNano())
+	f, err := os.Create(filepath.Join(sp.dir, name))
+	if err != nil {
+		return err
+	}
+
+	sp.current = f
+	sp.written = 0
+	return nil
+}
+
+func (sp *spool) rotateLocked() error {
+	if err := sp.finalizeLocked(); err != nil {
+		return err
+	}
+	return sp.openSegmentLocked()
+}
+
+// finalizeLocked writes the completion marker to the active segment and
+// closes it.
+func (sp *spool) finalizeLocked() error {
+	if sp.current == nil {
+		return nil
+	}
+
+	if _, err := sp.current.WriteString(segmentCompleteMarker + "\n"); err != nil {
+		return err
+	}
+	if err := sp.current.Close(); err != nil {
+		return err
+	}
+
+	sp.current = nil
+	return nil
+}
+
+func (sp *spool) close() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	return sp.finalizeLocked()
+}
+
+// segmentIDs lists the spool's segment files, oldest first, as the IDs
+// accepted by Ack.
+func (sp *spool) segmentIDs() ([]string, error) {
+	entries, err := os.ReadDir(sp.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".ndjson") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(entry.Name(), ".ndjson"))
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Replay re-reads events spilled to disk since from, in segment order, and
+// returns a channel that's closed once every segment has been read or ctx
+// is cancelled. It requires WithSpillDir to have been set.
+func (s *SessionManager) Replay(ctx context.Context, from time.Time) (<-chan event.Event, error) {
+	if s.spill == nil {
+		return nil, fmt.Errorf("fs: Replay requires WithSpillDir to be configured")
+	}
+
+	ids, err := s.spill.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan event.Event)
+
+	go func() {
+		defer close(out)
+
+		for _, id := range ids {
+			if err := s.replaySegment(ctx, id, from, out); err != nil {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (s *SessionManager) replaySegment(ctx context.Context, id string, from time.Time, out chan<- event.Event) error {
+	f, err := os.Open(filepath.Join(s.spill.dir, id+".ndjson"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || string(line) == segmentCompleteMarker {
+			continue
+		}
+
+		var rec spillRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// Corrupt line; skip it rather than aborting the whole replay.
+			continue
+		}
+
+		if rec.Timestamp < from.UnixNano() {
+			continue
+		}
+
+		evt := decodeRecord(rec)
+		if evt == nil {
+			continue
+		}
+
+		select {
+		case out <- evt:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Ack deletes a spill segment once its consumer has durably processed it,
+// compacting disk usage. segmentID comes from Segments.
+func (s *SessionManager) Ack(segmentID string) error {
+	if s.spill == nil {
+		return fmt.Errorf("fs: Ack requires WithSpillDir to be configured")
+	}
+
+	return os.Remove(filepath.Join(s.spill.dir, segmentID+".ndjson"))
+}
+
+// Segments lists the IDs of spill segments currently on disk, oldest
+// first, for use with Ack.
+func (s *SessionManager) Segments() ([]string, error) {
+	if s.spill == nil {
+		return nil, fmt.Errorf("fs: Segments requires WithSpillDir to be configured")
+	}
+
+	return s.spill.segmentIDs()
+}
@@ -0,0 +1,228 @@
+package mcp
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alex-ilgayev/mcpspy/pkg/event"
+)
+
+// defaultPendingTTL bounds how long a Request waits for its Response before
+// it's evicted and counted as an orphan.
+const defaultPendingTTL = 30 * time.Second
+
+// MCPCallEvent is an MCP request paired with its response, correlated by
+// PID and JSON-RPC id.
+type MCPCallEvent struct {
+	PID       uint32
+	FilePtr   uint64
+	Method    string
+	Params    json.RawMessage
+	Result    json.RawMessage
+	Error     *RPCError
+	LatencyNs int64
+}
+
+// pendingKey identifies an in-flight request awaiting its response. id is
+// the JSON-RPC id's raw encoding, which the spec requires the response to
+// echo back verbatim.
+//
+// Correlation is scoped to pid+id, not pid+filePtr+id: for stdio MCP
+// transports the request (written to the server's stdin) and the response
+// (read from the server's stdout) traverse different `struct file`s, so
+// they never share a FilePtr. The JSON-RPC id is already unique within a
+// single client process, which is all the spec guarantees.
+type pendingKey struct {
+	pid uint32
+	id  string
+}
+
+// pendingCall is a Request waiting to be paired with its Response.
+type pendingCall struct {
+	method    string
+	params    json.RawMessage
+	startedAt time.Time
+}
+
+// Correlator consumes the FSJsonEvents produced by a fs.SessionManager,
+// decodes them as JSON-RPC 2.0, and pairs each Request with its Response
+// into an MCPCallEvent. Every event it's given is also forwarded unchanged
+// on Events, so a caller can subscribe to both raw and correlated traffic
+// from a single Correlator. Sends on Events and MCPCalls don't block: an
+// event or call that finds its channel full is dropped.
+type Correlator struct {
+	mu      sync.Mutex
+	pending map[pendingKey]pendingCall
+
+	pendingTTL time.Duration
+
+	eventCh chan event.Event
+	callCh  chan *MCPCallEvent
+
+	orphans atomic.Uint64
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewCorrelator creates a new Correlator. By default unmatched requests are
+// evicted after defaultPendingTTL; use WithPendingTTL to override.
+func NewCorrelator(opts ...Option) *Correlator {
+	c := &Correlator{
+		pending:    make(map[pendingKey]pendingCall),
+		pendingTTL: defaultPendingTTL,
+		eventCh:    make(chan event.Event, 100),
+		callCh:     make(chan *MCPCallEvent, 100),
+		stopCh:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.wg.Add(1)
+	go c.reapExpired()
+
+	return c
+}
+
+// ProcessEvent forwards evt unchanged on Events (dropping it if Events is
+// full) and, if it's an
+// *event.FSJsonEvent, decodes its payload as one or more JSON-RPC 2.0
+// envelopes and feeds them into the correlator.
+func (c *Correlator) ProcessEvent(evt event.Event) error {
+	select {
+	case c.eventCh <- evt:
+	default:
+	}
+
+	jsonEvt, ok := evt.(*event.FSJsonEvent)
+	if !ok {
+		return nil
+	}
+
+	envelopes, err := DecodeEnvelopes(jsonEvt.Payload)
+	if err != nil {
+		return err
+	}
+
+	for _, env := range envelopes {
+		c.processEnvelope(jsonEvt.PID, jsonEvt.FilePtr, env)
+	}
+
+	return nil
+}
+
+// processEnvelope stashes Requests, pairs Responses/Errors against a
+// previously stashed Request, and counts unmatched Responses/Errors as
+// orphans. Notifications carry no id to correlate and are ignored.
+func (c *Correlator) processEnvelope(pid uint32, filePtr uint64, env Envelope) {
+	switch env.Kind {
+	case EnvelopeRequest:
+		key := pendingKey{pid: pid, id: string(env.ID)}
+
+		c.mu.Lock()
+		c.pending[key] = pendingCall{
+			method:    env.Method,
+			params:    env.Params,
+			startedAt: time.Now(),
+		}
+		c.mu.Unlock()
+
+	case EnvelopeResponse, EnvelopeError:
+		key := pendingKey{pid: pid, id: string(env.ID)}
+
+		c.mu.Lock()
+		call, ok := c.pending[key]
+		if ok {
+			delete(c.pending, key)
+		}
+		c.mu.Unlock()
+
+		if !ok {
+			c.orphans.Add(1)
+			return
+		}
+
+		mcpEvt := &MCPCallEvent{
+			PID:       pid,
+			FilePtr:   filePtr,
+			Method:    call.method,
+			Params:    call.params,
+			Result:    env.Result,
+			Error:     env.Error,
+			LatencyNs: time.Since(call.startedAt).Nanoseconds(),
+		}
+
+		select {
+		case c.callCh <- mcpEvt:
+		default:
+		}
+	}
+}
+
+// reapExpired periodically evicts requests that have waited longer than
+// pendingTTL for a response, counting each as an orphan.
+func (c *Correlator) reapExpired() {
+	defer c.wg.Done()
+
+	interval := c.pendingTTL / 4
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			c.reapOnce()
+		}
+	}
+}
+
+func (c *Correlator) reapOnce() {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, call := range c.pending {
+		if now.Sub(call.startedAt) >= c.pendingTTL {
+			delete(c.pending, key)
+			c.orphans.Add(1)
+		}
+	}
+}
+
+// Events returns a channel of the raw events this Correlator was given,
+// unchanged, alongside the correlated calls on MCPCalls.
+func (c *Correlator) Events() <-chan event.Event {
+	return c.eventCh
+}
+
+// MCPCalls returns a channel of correlated MCP request/response pairs.
+func (c *Correlator) MCPCalls() <-chan *MCPCallEvent {
+	return c.callCh
+}
+
+// Orphans returns the number of Responses/Errors that never matched a
+// pending Request, plus Requests evicted after waiting longer than
+// pendingTTL for one.
+func (c *Correlator) Orphans() uint64 {
+	return c.orphans.Load()
+}
+
+// Close stops the TTL eviction goroutine and closes Events and MCPCalls.
+func (c *Correlator) Close() {
+	close(c.stopCh)
+	c.wg.Wait()
+
+	close(c.eventCh)
+	close(c.callCh)
+}
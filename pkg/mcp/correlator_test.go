@@ -0,0 +1,265 @@
+package mcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alex-ilgayev/mcpspy/pkg/event"
+)
+
+func jsonEvent(pid uint32, filePtr uint64, eventType event.EventType, payload string) *event.FSJsonEvent {
+	return &event.FSJsonEvent{
+		EventHeader: event.EventHeader{
+			EventType: eventType,
+			PID:       pid,
+		},
+		FilePtr: filePtr,
+		Payload: []byte(payload),
+	}
+}
+
+func TestDecodeEnvelopes_Request(t *testing.T) {
+	envs, err := DecodeEnvelopes([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"a":1}}`))
+	if err != nil {
+		t.Fatalf("DecodeEnvelopes failed: %v", err)
+	}
+	if len(envs) != 1 {
+		t.Fatalf("expected 1 envelope, got %d", len(envs))
+	}
+	if envs[0].Kind != EnvelopeRequest {
+		t.Errorf("expected EnvelopeRequest, got %v", envs[0].Kind)
+	}
+	if envs[0].Method != "tools/call" {
+		t.Errorf("expected method tools/call, got %q", envs[0].Method)
+	}
+}
+
+func TestDecodeEnvelopes_NotificationHasNoID(t *testing.T) {
+	envs, err := DecodeEnvelopes([]byte(`{"jsonrpc":"2.0","method":"notifications/progress"}`))
+	if err != nil {
+		t.Fatalf("DecodeEnvelopes failed: %v", err)
+	}
+	if len(envs) != 1 || envs[0].Kind != EnvelopeNotification {
+		t.Fatalf("expected a single EnvelopeNotification, got %+v", envs)
+	}
+}
+
+func TestDecodeEnvelopes_Response(t *testing.T) {
+	envs, err := DecodeEnvelopes([]byte(`{"jsonrpc":"2.0","id":1,"result":{"ok":true}}`))
+	if err != nil {
+		t.Fatalf("DecodeEnvelopes failed: %v", err)
+	}
+	if len(envs) != 1 || envs[0].Kind != EnvelopeResponse {
+		t.Fatalf("expected a single EnvelopeResponse, got %+v", envs)
+	}
+}
+
+func TestDecodeEnvelopes_Error(t *testing.T) {
+	envs, err := DecodeEnvelopes([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"not found"}}`))
+	if err != nil {
+		t.Fatalf("DecodeEnvelopes failed: %v", err)
+	}
+	if len(envs) != 1 || envs[0].Kind != EnvelopeError {
+		t.Fatalf("expected a single EnvelopeError, got %+v", envs)
+	}
+	if envs[0].Error.Code != -32601 {
+		t.Errorf("expected error code -32601, got %d", envs[0].Error.Code)
+	}
+}
+
+func TestDecodeEnvelopes_BatchArray(t *testing.T) {
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"a"},{"jsonrpc":"2.0","id":2,"result":{}}]`
+	envs, err := DecodeEnvelopes([]byte(batch))
+	if err != nil {
+		t.Fatalf("DecodeEnvelopes failed: %v", err)
+	}
+	if len(envs) != 2 {
+		t.Fatalf("expected 2 envelopes, got %d", len(envs))
+	}
+	if envs[0].Kind != EnvelopeRequest || envs[1].Kind != EnvelopeResponse {
+		t.Errorf("unexpected envelope kinds: %+v", envs)
+	}
+}
+
+func TestCorrelator_PairsRequestAndResponse(t *testing.T) {
+	c := NewCorrelator()
+	defer c.Close()
+
+	req := jsonEvent(100, 0xabc, event.EventTypeFSJsonWrite, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"x":1}}`)
+	if err := c.ProcessEvent(req); err != nil {
+		t.Fatalf("ProcessEvent(request) failed: %v", err)
+	}
+
+	resp := jsonEvent(100, 0xabc, event.EventTypeFSJsonRead, `{"jsonrpc":"2.0","id":7,"result":{"y":2}}`)
+	if err := c.ProcessEvent(resp); err != nil {
+		t.Fatalf("ProcessEvent(response) failed: %v", err)
+	}
+
+	select {
+	case call := <-c.MCPCalls():
+		if call.Method != "tools/call" {
+			t.Errorf("expected method tools/call, got %q", call.Method)
+		}
+		if string(call.Result) != `{"y":2}` {
+			t.Errorf("expected result {\"y\":2}, got %q", call.Result)
+		}
+		if call.LatencyNs < 0 {
+			t.Errorf("expected non-negative latency, got %d", call.LatencyNs)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("no MCPCallEvent received")
+	}
+
+	if c.Orphans() != 0 {
+		t.Errorf("expected 0 orphans, got %d", c.Orphans())
+	}
+}
+
+func TestCorrelator_RawEventsPassThroughUnchanged(t *testing.T) {
+	c := NewCorrelator()
+	defer c.Close()
+
+	raw := jsonEvent(1, 2, event.EventTypeFSJsonRead, `{"jsonrpc":"2.0","method":"notifications/progress"}`)
+	if err := c.ProcessEvent(raw); err != nil {
+		t.Fatalf("ProcessEvent failed: %v", err)
+	}
+
+	select {
+	case evt := <-c.Events():
+		if evt != event.Event(raw) {
+			t.Errorf("expected the raw event to pass through unchanged")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("no raw event received")
+	}
+}
+
+func TestCorrelator_OrphanResponseIsCounted(t *testing.T) {
+	c := NewCorrelator()
+	defer c.Close()
+
+	resp := jsonEvent(1, 2, event.EventTypeFSJsonRead, `{"jsonrpc":"2.0","id":99,"result":{}}`)
+	if err := c.ProcessEvent(resp); err != nil {
+		t.Fatalf("ProcessEvent failed: %v", err)
+	}
+
+	if c.Orphans() != 1 {
+		t.Errorf("expected 1 orphan, got %d", c.Orphans())
+	}
+}
+
+func TestCorrelator_UnmatchedRequestExpiresAsOrphan(t *testing.T) {
+	c := NewCorrelator(WithPendingTTL(20 * time.Millisecond))
+	defer c.Close()
+
+	req := jsonEvent(1, 2, event.EventTypeFSJsonWrite, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	if err := c.ProcessEvent(req); err != nil {
+		t.Fatalf("ProcessEvent failed: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for c.Orphans() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if c.Orphans() != 1 {
+		t.Fatalf("expected the unmatched request to be evicted as an orphan, got %d", c.Orphans())
+	}
+
+	// The response arriving late should now find nothing pending and be
+	// counted as a second orphan rather than a match.
+	resp := jsonEvent(1, 2, event.EventTypeFSJsonRead, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	if err := c.ProcessEvent(resp); err != nil {
+		t.Fatalf("ProcessEvent failed: %v", err)
+	}
+	if c.Orphans() != 2 {
+		t.Errorf("expected 2 orphans after the late response, got %d", c.Orphans())
+	}
+}
+
+func TestCorrelator_BatchArraySplitsIntoIndividualCalls(t *testing.T) {
+	c := NewCorrelator()
+	defer c.Close()
+
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"a"},{"jsonrpc":"2.0","id":2,"method":"b"}]`
+	if err := c.ProcessEvent(jsonEvent(1, 2, event.EventTypeFSJsonWrite, batch)); err != nil {
+		t.Fatalf("ProcessEvent failed: %v", err)
+	}
+
+	responses := `[{"jsonrpc":"2.0","id":1,"result":{}},{"jsonrpc":"2.0","id":2,"result":{}}]`
+	if err := c.ProcessEvent(jsonEvent(1, 2, event.EventTypeFSJsonRead, responses)); err != nil {
+		t.Fatalf("ProcessEvent failed: %v", err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case call := <-c.MCPCalls():
+			seen[call.Method] = true
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("expected 2 correlated calls from the batch")
+		}
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Errorf("expected calls for both methods a and b, got %+v", seen)
+	}
+}
+
+func TestCorrelator_DifferentProcessesDoNotCrossMatch(t *testing.T) {
+	c := NewCorrelator()
+	defer c.Close()
+
+	req := jsonEvent(1, 0x1, event.EventTypeFSJsonWrite, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	if err := c.ProcessEvent(req); err != nil {
+		t.Fatalf("ProcessEvent failed: %v", err)
+	}
+
+	// Same id, different PID: must not be treated as the matching response
+	// for the request above.
+	resp := jsonEvent(2, 0x1, event.EventTypeFSJsonRead, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	if err := c.ProcessEvent(resp); err != nil {
+		t.Fatalf("ProcessEvent failed: %v", err)
+	}
+
+	select {
+	case call := <-c.MCPCalls():
+		t.Fatalf("expected no correlated call across processes, got %+v", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if c.Orphans() != 1 {
+		t.Errorf("expected 1 orphan, got %d", c.Orphans())
+	}
+}
+
+func TestCorrelator_PairsAcrossDifferentFilePtrs(t *testing.T) {
+	c := NewCorrelator()
+	defer c.Close()
+
+	// Stdio MCP traffic: the request travels over the client's stdout fd
+	// (the server's stdin) and the response arrives over a different fd
+	// (the server's stdout), so they never share a FilePtr. Same PID and id
+	// must still be enough to pair them.
+	req := jsonEvent(42, 0x1, event.EventTypeFSJsonWrite, `{"jsonrpc":"2.0","id":3,"method":"tools/call"}`)
+	if err := c.ProcessEvent(req); err != nil {
+		t.Fatalf("ProcessEvent(request) failed: %v", err)
+	}
+
+	resp := jsonEvent(42, 0x2, event.EventTypeFSJsonRead, `{"jsonrpc":"2.0","id":3,"result":{}}`)
+	if err := c.ProcessEvent(resp); err != nil {
+		t.Fatalf("ProcessEvent(response) failed: %v", err)
+	}
+
+	select {
+	case call := <-c.MCPCalls():
+		if call.Method != "tools/call" {
+			t.Errorf("expected method tools/call, got %q", call.Method)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("expected the request and response to correlate across FilePtrs")
+	}
+
+	if c.Orphans() != 0 {
+		t.Errorf("expected 0 orphans, got %d", c.Orphans())
+	}
+}
@@ -0,0 +1,126 @@
+// Package mcp decodes the raw JSON payloads captured by pkg/fs as JSON-RPC
+// 2.0 envelopes and correlates MCP requests with their responses.
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// EnvelopeKind classifies a decoded JSON-RPC 2.0 message.
+type EnvelopeKind int
+
+const (
+	EnvelopeUnknown EnvelopeKind = iota
+	EnvelopeRequest
+	EnvelopeNotification
+	EnvelopeResponse
+	EnvelopeError
+)
+
+func (k EnvelopeKind) String() string {
+	switch k {
+	case EnvelopeRequest:
+		return "request"
+	case EnvelopeNotification:
+		return "notification"
+	case EnvelopeResponse:
+		return "response"
+	case EnvelopeError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// RPCError is a JSON-RPC 2.0 error object.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Envelope is a single decoded JSON-RPC 2.0 message. Which fields are set
+// depends on Kind: Method/Params for requests and notifications, ID for
+// requests and responses, Result for responses, Error for errors.
+type Envelope struct {
+	Kind   EnvelopeKind
+	ID     json.RawMessage
+	Method string
+	Params json.RawMessage
+	Result json.RawMessage
+	Error  *RPCError
+}
+
+// jsonRPCMessage mirrors the JSON-RPC 2.0 wire format for decoding.
+type jsonRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// DecodeEnvelopes decodes a captured payload into one or more JSON-RPC 2.0
+// envelopes. Batch arrays ([...]) are split into individual envelopes.
+func DecodeEnvelopes(payload []byte) ([]Envelope, error) {
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	if trimmed[0] == '[' {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("decode JSON-RPC batch: %w", err)
+		}
+
+		envelopes := make([]Envelope, 0, len(raw))
+		for _, r := range raw {
+			env, err := decodeEnvelope(r)
+			if err != nil {
+				return nil, err
+			}
+			envelopes = append(envelopes, env)
+		}
+		return envelopes, nil
+	}
+
+	env, err := decodeEnvelope(trimmed)
+	if err != nil {
+		return nil, err
+	}
+	return []Envelope{env}, nil
+}
+
+func decodeEnvelope(raw json.RawMessage) (Envelope, error) {
+	var msg jsonRPCMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return Envelope{}, fmt.Errorf("decode JSON-RPC message: %w", err)
+	}
+
+	env := Envelope{
+		ID:     msg.ID,
+		Method: msg.Method,
+		Params: msg.Params,
+		Result: msg.Result,
+		Error:  msg.Error,
+	}
+
+	switch {
+	case msg.Error != nil:
+		env.Kind = EnvelopeError
+	case msg.Method != "" && len(msg.ID) > 0:
+		env.Kind = EnvelopeRequest
+	case msg.Method != "":
+		env.Kind = EnvelopeNotification
+	case len(msg.ID) > 0:
+		env.Kind = EnvelopeResponse
+	default:
+		env.Kind = EnvelopeUnknown
+	}
+
+	return env, nil
+}
@@ -0,0 +1,14 @@
+package mcp
+
+import "time"
+
+// Option configures a Correlator at construction time.
+type Option func(*Correlator)
+
+// WithPendingTTL bounds how long a Request waits for its matching Response
+// before it's evicted as an orphan. Defaults to defaultPendingTTL.
+func WithPendingTTL(d time.Duration) Option {
+	return func(c *Correlator) {
+		c.pendingTTL = d
+	}
+}